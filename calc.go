@@ -1,13 +1,13 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"time"
 	"strconv"
 	"flag"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -19,42 +19,70 @@ import (
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/gmail/v1"
 
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"github.com/Labutin/GMailMessagesSize/mstore"
+	"github.com/Labutin/GMailMessagesSize/report"
+	"github.com/Labutin/GMailMessagesSize/storage"
+	"github.com/Labutin/GMailMessagesSize/storage/mongo"
+	"github.com/Labutin/GMailMessagesSize/storage/postgres"
+	"github.com/Labutin/GMailMessagesSize/storage/sqlite"
+	"github.com/Labutin/GMailMessagesSize/tokenstore"
 )
 
-const database string = "gmail"
-const labelCollection string = "labels"
-const messageCollection string = "messages"
-
 var labelsFlag stringslice
-// getClient uses a Context and Config to retrieve a Token
-// then generate a Client. It returns the generated Client.
-func getClient(ctx context.Context, config *oauth2.Config) *http.Client {
-	cacheFile, err := tokenCacheFile()
-	if err != nil {
-		log.Fatalf("Unable to get path to cached credential file. %v", err)
-	}
-	tok, err := tokenFromFile(cacheFile)
+
+// getClient uses a Context and Config to retrieve a Token from store
+// (or, failing that, the web) then generate a Client. It returns the
+// generated Client.
+func getClient(ctx context.Context, config *oauth2.Config, store tokenstore.Store) *http.Client {
+	tok, err := store.Load()
 	if err != nil {
 		tok = getTokenFromWeb(config)
-		saveToken(cacheFile, tok)
+		if err := store.Save(tok); err != nil {
+			log.Fatalf("Unable to cache oauth token: %v", err)
+		}
 	}
 	return config.Client(ctx, tok)
 }
 
-// getTokenFromWeb uses Config to request a Token.
-// It returns the retrieved Token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the " +
-	"authorization code: \n%v\n", authURL)
+// oauthState is the anti-CSRF token getTokenFromWeb sends as the OAuth
+// "state" parameter and checks for on the loopback redirect.
+const oauthState = "state-token"
 
-	var code string
-	if _, err := fmt.Scan(&code); err != nil {
-		log.Fatalf("Unable to read authorization code %v", err)
+// getTokenFromWeb uses Config to request a Token, completing the OAuth
+// flow via a loopback redirect: Google no longer supports the
+// copy-paste-a-code "out of band" flow, so this listens on a random
+// 127.0.0.1 port, sends the user there, and captures the code Google
+// redirects back with.
+func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("Unable to start loopback OAuth listener: %v", err)
 	}
+	defer listener.Close()
+	config.RedirectURL = fmt.Sprintf("http://127.0.0.1:%d", listener.Addr().(*net.TCPAddr).Port)
+
+	authURL := config.AuthCodeURL(oauthState, oauth2.AccessTypeOffline)
+	fmt.Printf("Go to the following link in your browser: \n%v\n", authURL)
 
+	codeCh := make(chan string, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("state") != oauthState {
+			fmt.Fprint(w, "Invalid state parameter.")
+			return
+		}
+		if code := r.URL.Query().Get("code"); code != "" {
+			fmt.Fprint(w, "Authorization received, you can close this tab now.")
+			codeCh <- code
+		} else {
+			fmt.Fprint(w, "Missing authorization code.")
+		}
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	code := <-codeCh
 	tok, err := config.Exchange(oauth2.NoContext, code)
 	if err != nil {
 		log.Fatalf("Unable to retrieve token from web %v", err)
@@ -62,8 +90,8 @@ func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
 	return tok
 }
 
-// tokenCacheFile generates credential file path/filename.
-// It returns the generated credential path/filename.
+// tokenCacheFile generates credential file path/filename for the "file"
+// and "encrypted" tokenstore backends.
 func tokenCacheFile() (string, error) {
 	usr, err := user.Current()
 	if err != nil {
@@ -75,315 +103,100 @@ func tokenCacheFile() (string, error) {
 		url.QueryEscape("gmail-go-quickstart.json")), err
 }
 
-// tokenFromFile retrieves a Token from a given file path.
-// It returns the retrieved Token and any read error encountered.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	t := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(t)
-	defer f.Close()
-	return t, err
-}
-
-// saveToken uses a file path to create a file and store the
-// token in it.
-func saveToken(file string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", file)
-	f, err := os.Create(file)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
-	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
-}
-
-type Message struct {
-	Id string
-	LabelIds []string
-	Processed bool
-	InternalDate time.Time
-}
-
-type Label struct {
-	Id string
-	Name string
-}
-
-// reInitCollectionForLabels creates collection "labels" in "gmail" database
-// and creates indexes
-func reInitCollectionForLabels(session *mgo.Session) (*mgo.Collection) {
-	lc := session.DB(database).C(labelCollection)
-	err := lc.DropCollection()
-	index := mgo.Index{
-		Key: [] string{"id"},
-		Unique: true,
-	}
-	err = lc.EnsureIndex(index)
-	if err != nil {
-		log.Fatalf("Can't create index for '%s' collection: %v", labelCollection, err)
-	}
-	return lc
-}
-
-// reInitCollectionForMessages creates collection "messages" in "gmail" database
-// and creates indexes
-func reInitCollectionForMessages(session *mgo.Session) (*mgo.Collection) {
-	mc := session.DB(database).C(messageCollection)
-//	err := mc.DropCollection()
-	index := mgo.Index{
-		Key: [] string{"id"},
-		Unique: true,
-	}
-	err := mc.EnsureIndex(index)
-	if err != nil {
-		log.Fatalf("Can't create index for '%s' collection: %v", messageCollection, err)
-	}
-	index = mgo.Index {
-		Key: [] string{"processed"},
-	}
-	err = mc.EnsureIndex(index)
-	if err != nil {
-		log.Fatalf("Can't create index for '%s' collection: %v", messageCollection, err)
-	}
-	index = mgo.Index {
-		Key: [] string{"internaldate"},
-	}
-	err = mc.EnsureIndex(index)
-	if err != nil {
-		log.Fatalf("Can't create index for '%s' collection: %v", messageCollection, err)
-	}
-	index = mgo.Index {
-		Key: [] string{"labelids"},
-	}
-	err = mc.EnsureIndex(index)
-	if err != nil {
-		log.Fatalf("Can't create index for '%s' collection: %v", messageCollection, err)
-	}
-	return mc
-}
-
-// importLabels get Labels from GMail and store its in "labels" collection
-func importLabels(srv *gmail.Service, session *mgo.Session) {
-	user := "me"
-	r, err := srv.Users.Labels.List(user).Do()
+// importLabels get Labels from the message store and store its in the backend
+func importLabels(store mstore.MessageStore, backend storage.Backend) {
+	labels, err := store.ListLabels()
 	if err != nil {
 		log.Fatalf("Unable to retrieve labels. %v", err)
 	}
 
-	lc := reInitCollectionForLabels(session)
+	if err := backend.ResetLabels(); err != nil {
+		log.Fatalf("Can't reset labels: %v", err)
+	}
 
-	if (len(r.Labels) > 0) {
-		for _, l := range r.Labels {
-			err = lc.Insert(&Label{l.Id, l.Name})
-			if err != nil {
-				log.Fatal(err)
-			}
+	for _, l := range labels {
+		err = backend.UpsertLabel(storage.Label{Id: l.Id, Name: l.Name})
+		if err != nil {
+			log.Fatal(err)
 		}
 	}
-	fmt.Printf("Imported labels: %d\n", len(r.Labels))
+	fmt.Printf("Imported labels: %d\n", len(labels))
 }
 
 // findLastImportedDay finds date of last message and minus 2 days
-func findLastImportedDay(session *mgo.Session) (string) {
-	mc := session.DB(database).C(messageCollection)
-	lastMessage := new (Message)
-	err := mc.Find(nil).Sort("-internaldate").One(&lastMessage)
+func findLastImportedDay(backend storage.Backend) (string, error) {
+	lastInternalDate, err := backend.LastInternalDate()
 	if err != nil {
-		if err.Error() == "not found" {
-			lastMessage.InternalDate = time.Date(1900, time.January, 1, 1, 0, 0, 0, time.UTC)
-		} else {
-			log.Fatalf("%v", err)
-		}
+		return "", err
 	}
-	lastDateMinus2Days := lastMessage.InternalDate.Add(time.Duration(-48) * time.Hour)
+	lastDateMinus2Days := lastInternalDate.Add(time.Duration(-48) * time.Hour)
 	lastDateMinus2DaysStr :=strconv.Itoa(
 		lastDateMinus2Days.Year()) +
 		"/" + strconv.Itoa(int(lastDateMinus2Days.Month())) +
 		"/" + strconv.Itoa(lastDateMinus2Days.Day())
-	return lastDateMinus2DaysStr
+	return lastDateMinus2DaysStr, nil
 }
 
-// importMessages get Messages list from GMail and store its in "Messages" collection
-// Doesn't collect info about messages (only messages ids)
-func importMessages(srv *gmail.Service, session *mgo.Session) {
-	user := "me"
-	importFromDate := findLastImportedDay(session)
-	r, err := srv.Users.Messages.List(user).IncludeSpamTrash(true).Q("newer:"+importFromDate).Do()
+// importMessages get Messages list from the message store and store its in the backend
+// Doesn't collect info about messages (only messages ids). Returns an
+// error instead of exiting so that daemon mode can log it and retry on
+// the next tick rather than taking down the whole process.
+func importMessages(store mstore.MessageStore, backend storage.Backend) error {
+	importFromDate, err := findLastImportedDay(backend)
 	if err != nil {
-		log.Fatalf("Unable to retrieve messages. %v", err)
+		return err
 	}
-	var messageToInsert = new(Message)
-	messageToInsert.Processed = false
-	messageToInsert.InternalDate = time.Date(1900, time.January, 1, 1, 0, 0, 0, time.UTC)
-	count := 0
-	mc := reInitCollectionForMessages(session)
-	for (len(r.Messages) > 0) {
-		for _, message := range r.Messages {
-//			fmt.Printf("%+v\n", message);
-			messageToInsert.Id = message.Id
-			err = mc.Insert(&messageToInsert)
-			if err != nil {
-				if (!mgo.IsDup(err)) {
-					log.Fatalf("Can't insert message: %v", err)
-				}
-			}
-		}
-		count += len(r.Messages)
-		fmt.Printf("Processed %d messages\n", count)
-		if r.NextPageToken == "" {
-			break
-		}	else {
-			r, err = srv.Users.Messages.List(user).IncludeSpamTrash(true).PageToken(r.NextPageToken).Q("newer:"+importFromDate).Do()
-			if err != nil {
-				log.Fatalf("Can't list messages: %v\n", err)
-			}
-		}
+	headers, err := store.ListMessageIds(importFromDate)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve messages: %v", err)
 	}
-}
-
-// processMessage Get information about messages gotten from input chan
-func processMessage(srv *gmail.Service, session *mgo.Session, in <-chan string) {
-	user := "me"
-	mCollection := session.DB(database).C(messageCollection)
-	for messageId := range in {
-		fmt.Print(".")
-		message, err := srv.Users.Messages.Get(user, messageId).Fields("internalDate,labelIds,sizeEstimate").Do()
-		if err != nil {
-			switch err.Error() {
-			default:
-				fmt.Printf("Retrive message error: %v\n", err)
-				return
-			case "googleapi: Error 403: User Rate Limit Exceeded, userRateLimitExceeded":
-				fmt.Print("S")
-				time.Sleep(5 * time.Second)
-			case "googleapi: Error 404: Not Found, notFound":
-				err = mCollection.Remove(bson.M{"id": messageId})
-				fmt.Print("NF")
-				if err != nil {
-					fmt.Printf("Can't dete message info: %v\n", err)
-				}
-			}
-		} else {
-			err = mCollection.Update(bson.M{"id": messageId}, bson.M{"$set":
-				bson.M{
-					"processed": true,
-					"SizeEstimate": message.SizeEstimate,
-					"labelids": message.LabelIds,
-					"internaldate": time.Unix(message.InternalDate / 1000, 0)}})
-			if err != nil {
-				log.Fatalf("Can't update message info: %v\n", err)
-			}
+	for _, header := range headers {
+		if err := backend.UpsertMessage(header.Id); err != nil {
+			return fmt.Errorf("can't insert message: %v", err)
 		}
 	}
+	fmt.Printf("Processed %d messages\n", len(headers))
+	return nil
 }
 
-// processMessages Process all messages in queue (processed==false)
-func processMessages(srv *gmail.Service, session *mgo.Session, procNum int) {
-	if (procNum < 1 || procNum > 50) {
-		log.Fatal("Wrong procNum. Min=1 Max=50")
-	}
-	flagContinue := true
-	var messages []Message
-	messagesCollection := session.DB(database).C(messageCollection)
-	out := make(chan string)
-	for i:= 0; i < procNum; i++ {
-		go processMessage(srv, session, out)
-	}
-	count := 0
-	for flagContinue {
-		err := messagesCollection.Find(bson.M{"processed": false}).Limit(100).All(&messages)
+// showReport runs the --report named kind against backend and writes it to
+// --out (or stdout) in --format, restricting the "labels" report to
+// labelsFlag when given.
+func showReport(backend storage.Backend, kind string, format string, topN int, outPath string) {
+	out := os.Stdout
+	if outPath != "" {
+		f, err := os.Create(outPath)
 		if err != nil {
-			log.Fatalf("Can't get messages for process: %v", err)
-		}
-		for _, m := range messages {
-			out <- m.Id
-		}
-		count += len(messages)
-		if count % 100 == 0 {
-			fmt.Printf("Procecced %d messages\n", count)
-		}
-		if len(messages) == 0 {
-			flagContinue = false
-		}
-	}
-}
-
-func showLabelSize(session *mgo.Session, labelIds []string) {
-	messagesCollection := session.DB(database).C(messageCollection)
-	labelsCollection := session.DB(database).C(labelCollection)
-	var labels []Label
-	labelsCollection.Find(bson.M{"id": bson.M{"$in": labelIds}}).All(&labels)
-	first := true
-	for _, l := range labels {
-		if !first {
-			fmt.Print(",")
-		}else{
-			first = false
-		}
-		fmt.Printf("%s", l.Id)
-	}
-	fmt.Print(";")
-	first = true
-	for _, l := range labels {
-		if !first {
-			fmt.Print(",")
-		}else{
-			first = false
+			log.Fatalf("Can't create output file: %v", err)
 		}
-		fmt.Printf("%s", l.Name)
+		defer f.Close()
+		out = f
 	}
-	fmt.Print(";")
-	res := bson.M{}
-	lIdsQuery := bson.M{}
-	if len(labelIds) == 1 {
-		lIdsQuery = bson.M{"$in": labelIds}
-	}else {
-		lIdsQuery = bson.M{"$all": labelIds, "$size": len(labelIds)}
+	if err := report.Run(backend, kind, format, topN, labelsFlag, out); err != nil {
+		log.Fatalf("Can't build report: %v", err)
 	}
-	err := messagesCollection.Pipe([]bson.M{
-		{"$match": bson.M{"labelids": lIdsQuery}},
-		{"$group": bson.M{"_id": nil,
-			"sum": bson.M{"$sum": "$SizeEstimate"},
-			"count": bson.M{"$sum": 1}}}}).One(&res)
-	if err != nil {
-		if err.Error() == "not found" {
-			res["sum"] = 0
-			res["count"] = 0
-		}else {
-			log.Fatalf("Can't calculate Label size: %v", err)
-		}
-	}
-	fmt.Printf("%d;%d\n", res["sum"], res["count"])
 }
 
-func showLabelSizes(session *mgo.Session) {
-	labelsCollection := session.DB(database).C(labelCollection)
-	var labels []Label
+// newStorageBackend opens the storage.Backend selected by --storage,
+// connecting to dsn.
+func newStorageBackend(kind string, dsn string) storage.Backend {
+	var backend storage.Backend
 	var err error
-	err = nil
-	if len(labelsFlag) == 0 {
-		err = labelsCollection.Find(nil).Sort("name").All(&labels)
+	switch kind {
+	case "mongo":
+		backend, err = mongo.New(dsn)
+	case "sqlite":
+		backend, err = sqlite.New(dsn)
+	case "postgres":
+		backend, err = postgres.New(dsn)
+	default:
+		log.Fatalf("Unknown storage: %s. Expected mongo, sqlite or postgres", kind)
+		return nil
 	}
 	if err != nil {
-		log.Fatalf("Can't get Labels list: %v", err)
-	}
-	fmt.Print("LabelId;Label name;Messages size;Messages count\n")
-	if len(labelsFlag) == 0 {
-		for _, label := range labels {
-			showLabelSize(session, []string{label.Id})
-		}
-	}else{
-		showLabelSize(session, labelsFlag)
+		log.Fatalf("Can't connect to %s storage: %v", kind, err)
 	}
-}
-
-// getMongoDBConnection init MongoDB connection
-func getMongoDBConnection(connectionString string) (*mgo.Session, error)  {
-	session, err := mgo.Dial(connectionString)
-	return session, err
+	return backend
 }
 
 // Define a type named "intslice" as a slice of ints
@@ -402,49 +215,117 @@ func (i *stringslice) Set(value string) error {
 	return nil
 }
 
-func main() {
-	ctx := context.Background()
+// newMessageStore builds the mstore.MessageStore selected by --source.
+func newMessageStore(source string, tokenStoreKind string) mstore.MessageStore {
+	switch source {
+	case "imap":
+		conf := &mstore.ImapConfig{
+			Url:      *flagImapUrl,
+			Username: *flagImapUser,
+			Password: *flagImapPassword,
+			Tls:      *flagImapTls,
+		}
+		store, err := mstore.NewImapStore(conf)
+		if err != nil {
+			log.Fatalf("Unable to connect to IMAP server: %v", err)
+		}
+		return store
+	case "gmail":
+		ctx := context.Background()
 
-	b, err := ioutil.ReadFile("client_secret.json")
-	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
-	}
+		b, err := ioutil.ReadFile("client_secret.json")
+		if err != nil {
+			log.Fatalf("Unable to read client secret file: %v", err)
+		}
 
-	config, err := google.ConfigFromJSON(b, gmail.GmailReadonlyScope)
-	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
-	}
-	client := getClient(ctx, config)
+		config, err := google.ConfigFromJSON(b, gmail.GmailReadonlyScope)
+		if err != nil {
+			log.Fatalf("Unable to parse client secret file to config: %v", err)
+		}
 
-	srv, err := gmail.New(client)
-	if err != nil {
-		log.Fatalf("Unable to retrieve gmail Client %v", err)
+		cacheFile, err := tokenCacheFile()
+		if err != nil {
+			log.Fatalf("Unable to get path to cached credential file. %v", err)
+		}
+		tokStore, err := tokenstore.New(tokenStoreKind, cacheFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		client := getClient(ctx, config, tokStore)
+
+		srv, err := gmail.New(client)
+		if err != nil {
+			log.Fatalf("Unable to retrieve gmail Client %v", err)
+		}
+		return mstore.NewGmailStore(srv)
+	default:
+		log.Fatalf("Unknown source: %s. Expected gmail or imap", source)
+		return nil
 	}
+}
 
+var (
+	flagImapUrl      *string
+	flagImapUser     *string
+	flagImapPassword *string
+	flagImapTls      *bool
+)
+
+func main() {
 	flagImportLabels := flag.Bool("importLabels", false, "Import Labels from GMail")
 	flagImportMessages := flag.Bool("importMessages", false, "Import Messages from GMail")
 	flagProcessMessages := flag.Bool("processMessages", false, "Process Messages (Collect sizes)")
 	flagShowSizes := flag.Bool("showSizes", false, "Show Labels sizes")
+	flagReport := flag.String("report", "labels", "Report to run with --showSizes: labels, senders, attachments, threads-top, histogram or timeseries")
+	flagFormat := flag.String("format", "table", "Report output format: table, csv or json")
+	flagOut := flag.String("out", "", "File to write the report to (default stdout)")
+	flagTopN := flag.Int("topN", 10, "Number of threads to show for --report=threads-top")
+	flagDaemon := flag.Bool("daemon", false, "Run as a long-lived daemon, periodically syncing instead of exiting")
 	procNum := flag.Int("procNum", 1, "Number councurrent processes")
-	flagMongoConnectString := flag.String("mongoConnectString", "127.0.0.1", "Mongo connection string")
+	flagStorage := flag.String("storage", "mongo", "Storage backend: mongo, sqlite or postgres")
+	flagDsn := flag.String("dsn", "127.0.0.1", "Storage connection string (Mongo connect string, SQLite file path, or Postgres DSN)")
+	flagSource := flag.String("source", "gmail", "Ingestion source: gmail or imap")
+	flagTokenStore := flag.String("tokenStore", "file", "OAuth token cache backend: file, encrypted or keyring")
+	flagImapUrl = flag.String("imapUrl", "", "IMAP server address (host:port), required for --source=imap")
+	flagImapUser = flag.String("imapUser", "", "IMAP username, required for --source=imap")
+	flagImapPassword = flag.String("imapPassword", "", "IMAP password, required for --source=imap")
+	flagImapTls = flag.Bool("imapTls", true, "Use TLS for the IMAP connection")
+	flagMaxRetries := flag.Int("maxRetries", 5, "Max retries per message on rate-limit/transient errors")
+	flagQps := flag.Float64("qps", defaultQps, "Gmail queries per second budget (250 quota units/sec / 5 units per messages.get)")
+	flagBurst := flag.Int("burst", 10, "Rate limiter burst size")
+	flagMetricsAddr := flag.String("metricsAddr", ":9109", "Address to serve the /metrics endpoint on (empty to disable)")
 	flag.Var(&labelsFlag, "l", "List of labels")
 	flag.Parse()
-	session, err := getMongoDBConnection(*flagMongoConnectString)
-	if err != nil {
-		log.Fatalf("Can't connect to MongoDB: %v", err)
+
+	if *flagMetricsAddr != "" {
+		startMetricsServer(*flagMetricsAddr)
+	}
+
+	store := newMessageStore(*flagSource, *flagTokenStore)
+
+	backend := newStorageBackend(*flagStorage, *flagDsn)
+	defer backend.Close()
+
+	procCfg := ProcessConfig{ProcNum: *procNum, MaxRetries: *flagMaxRetries, Qps: *flagQps, Burst: *flagBurst}
+	if *flagDaemon {
+		runDaemon(store, backend, procCfg)
+		return
 	}
-	defer session.Close()
 	if *flagImportLabels {
-		importLabels(srv, session)
+		importLabels(store, backend)
 	}
 	if *flagImportMessages {
-		importMessages(srv, session)
+		if err := importMessages(store, backend); err != nil {
+			log.Fatalf("Can't import messages: %v", err)
+		}
 	}
 	if *flagProcessMessages {
-		processMessages(srv, session, *procNum)
+		if err := processMessages(store, backend, procCfg); err != nil {
+			log.Fatalf("Can't process messages: %v", err)
+		}
 	}
 	if *flagShowSizes {
-		showLabelSizes(session)
+		showReport(backend, *flagReport, *flagFormat, *flagTopN, *flagOut)
 	}
 //	fmt.Printf("%s\n", message.Id)
 //	rM, err := srv.Users.Messages.Get(user, message.Id).Fields("sizeEstimate").Do()
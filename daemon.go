@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/Labutin/GMailMessagesSize/mstore"
+	"github.com/Labutin/GMailMessagesSize/storage"
+)
+
+const discoverInterval = 30 * time.Second
+const aggregateInterval = time.Hour
+
+// syncIncremental discovers new/changed/deleted messages since the last
+// run. If store supports mstore.HistorySyncer (currently only Gmail) it
+// uses the persisted historyId cursor; when the cursor has expired or
+// doesn't exist yet it re-runs importMessages' scan for messages newer
+// than the last imported day and saves a fresh cursor. That scan only
+// queues ids it hasn't seen: messages deleted or relabeled while there
+// was no valid cursor are not picked up. Stores without HistorySyncer
+// always get that same scan, like the non-daemon --importMessages flag.
+// Returns an error instead of exiting
+// so that runDaemon can log it and retry on the next tick rather than
+// taking down the whole process over a transient failure.
+func syncIncremental(store mstore.MessageStore, backend storage.Backend) error {
+	syncer, ok := store.(mstore.HistorySyncer)
+	if !ok {
+		return importMessages(store, backend)
+	}
+
+	historyId, err := backend.GetSyncState()
+	if err != nil {
+		return fmt.Errorf("can't load sync state: %v", err)
+	}
+	if historyId == 0 {
+		if err := importMessages(store, backend); err != nil {
+			return err
+		}
+		historyId, err = syncer.CurrentHistoryId()
+		if err != nil {
+			return fmt.Errorf("can't get current historyId: %v", err)
+		}
+		if err := backend.SaveSyncState(historyId); err != nil {
+			return fmt.Errorf("can't save sync state: %v", err)
+		}
+		return nil
+	}
+
+	events, newHistoryId, err := syncer.SyncHistory(historyId)
+	if err == mstore.ErrHistoryExpired {
+		fmt.Println("historyId expired, rescanning messages since the last imported day")
+		if err := importMessages(store, backend); err != nil {
+			return err
+		}
+		historyId, err = syncer.CurrentHistoryId()
+		if err != nil {
+			return fmt.Errorf("can't get current historyId: %v", err)
+		}
+		if err := backend.SaveSyncState(historyId); err != nil {
+			return fmt.Errorf("can't save sync state: %v", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't sync history: %v", err)
+	}
+
+	for _, ev := range events {
+		if ev.Deleted {
+			if err := backend.RemoveMessage(ev.MessageId); err != nil {
+				log.Printf("Can't remove message %s: %v\n", ev.MessageId, err)
+			}
+			continue
+		}
+		if err := backend.RequeueMessage(ev.MessageId); err != nil {
+			log.Printf("Can't queue message %s: %v\n", ev.MessageId, err)
+		}
+	}
+	if len(events) > 0 {
+		fmt.Printf("Synced %d history events\n", len(events))
+	}
+	if err := backend.SaveSyncState(newHistoryId); err != nil {
+		return fmt.Errorf("can't save sync state: %v", err)
+	}
+	return nil
+}
+
+// runDaemon keeps the process alive, periodically discovering new
+// messages (every discoverInterval), processing them (every
+// discoverInterval, right after discovery) and recomputing label size
+// aggregates (every aggregateInterval). SIGINT stops the loop cleanly.
+// A failed tick is logged and skipped rather than fatal: a transient
+// network/DB blip shouldn't take down a process meant to run unattended.
+func runDaemon(store mstore.MessageStore, backend storage.Backend, procCfg ProcessConfig) {
+	discoverTicker := time.NewTicker(discoverInterval)
+	defer discoverTicker.Stop()
+	aggregateTicker := time.NewTicker(aggregateInterval)
+	defer aggregateTicker.Stop()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+
+	fmt.Println("Daemon started. Press Ctrl+C to stop.")
+	for {
+		select {
+		case <-discoverTicker.C:
+			if err := syncIncremental(store, backend); err != nil {
+				log.Printf("Sync failed, will retry next tick: %v\n", err)
+				continue
+			}
+			if err := processMessages(store, backend, procCfg); err != nil {
+				log.Printf("Processing failed, will retry next tick: %v\n", err)
+			}
+		case <-aggregateTicker.C:
+			showReport(backend, "labels", "table", 0, "")
+		case <-sigCh:
+			fmt.Println("Shutting down...")
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// processMetrics tracks the counters surfaced on /metrics, so that users
+// can tune --procNum/--qps/--burst instead of guessing. All fields are
+// updated with the atomic package since they're written from every
+// worker goroutine.
+type processMetrics struct {
+	processed         uint64
+	retries           uint64
+	quotaBlockedNanos int64
+}
+
+var metrics = &processMetrics{}
+
+func (m *processMetrics) IncProcessed() {
+	atomic.AddUint64(&m.processed, 1)
+}
+
+func (m *processMetrics) IncRetries() {
+	atomic.AddUint64(&m.retries, 1)
+}
+
+func (m *processMetrics) AddQuotaBlocked(d time.Duration) {
+	atomic.AddInt64(&m.quotaBlockedNanos, int64(d))
+}
+
+// startMetricsServer serves processMetrics in Prometheus exposition
+// format on addr (e.g. ":9109") at /metrics, so processed/sec, retries
+// and quota-blocked time can be scraped while tuning concurrency.
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "# HELP gmailmessagessize_messages_processed_total Messages successfully processed\n")
+		fmt.Fprint(w, "# TYPE gmailmessagessize_messages_processed_total counter\n")
+		fmt.Fprintf(w, "gmailmessagessize_messages_processed_total %d\n", atomic.LoadUint64(&metrics.processed))
+
+		fmt.Fprint(w, "# HELP gmailmessagessize_retries_total Retries due to rate limits or transient errors\n")
+		fmt.Fprint(w, "# TYPE gmailmessagessize_retries_total counter\n")
+		fmt.Fprintf(w, "gmailmessagessize_retries_total %d\n", atomic.LoadUint64(&metrics.retries))
+
+		fmt.Fprint(w, "# HELP gmailmessagessize_quota_blocked_seconds_total Seconds workers spent waiting out rate limits\n")
+		fmt.Fprint(w, "# TYPE gmailmessagessize_quota_blocked_seconds_total counter\n")
+		fmt.Fprintf(w, "gmailmessagessize_quota_blocked_seconds_total %f\n",
+			time.Duration(atomic.LoadInt64(&metrics.quotaBlockedNanos)).Seconds())
+	})
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("metrics server stopped: %v\n", err)
+		}
+	}()
+}
@@ -0,0 +1,150 @@
+package mstore
+
+import (
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// gmailStore implements MessageStore on top of the Gmail REST API. It is
+// a thin wrapper around the calls the tool already made directly against
+// *gmail.Service.
+type gmailStore struct {
+	srv  *gmail.Service
+	user string
+}
+
+// NewGmailStore wraps srv as a MessageStore.
+func NewGmailStore(srv *gmail.Service) MessageStore {
+	return &gmailStore{srv: srv, user: "me"}
+}
+
+func (s *gmailStore) ListLabels() ([]Label, error) {
+	r, err := s.srv.Users.Labels.List(s.user).Do()
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]Label, 0, len(r.Labels))
+	for _, l := range r.Labels {
+		labels = append(labels, Label{Id: l.Id, Name: l.Name})
+	}
+	return labels, nil
+}
+
+func (s *gmailStore) ListMessageIds(afterDate string) ([]MessageHeader, error) {
+	var headers []MessageHeader
+	r, err := s.srv.Users.Messages.List(s.user).IncludeSpamTrash(true).Q("newer:" + afterDate).Do()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		for _, message := range r.Messages {
+			headers = append(headers, MessageHeader{Id: message.Id})
+		}
+		if r.NextPageToken == "" {
+			break
+		}
+		r, err = s.srv.Users.Messages.List(s.user).IncludeSpamTrash(true).
+			PageToken(r.NextPageToken).Q("newer:" + afterDate).Do()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return headers, nil
+}
+
+func (s *gmailStore) GetMessage(id string) (*MessageInfo, error) {
+	message, err := s.srv.Users.Messages.Get(s.user, id).
+		Fields("threadId,internalDate,labelIds,sizeEstimate,payload/headers,payload/parts").Do()
+	if err != nil {
+		return nil, err
+	}
+	var from string
+	var attachmentsSize int64
+	if message.Payload != nil {
+		from = headerValue(message.Payload.Headers, "From")
+		attachmentsSize = attachmentsSizeOf(message.Payload)
+	}
+	return &MessageInfo{
+		Id:              id,
+		LabelIds:        message.LabelIds,
+		SizeEstimate:    message.SizeEstimate,
+		InternalDate:    time.Unix(message.InternalDate/1000, 0),
+		From:            from,
+		ThreadId:        message.ThreadId,
+		AttachmentsSize: attachmentsSize,
+	}, nil
+}
+
+// headerValue returns the value of the first header named name, or "".
+func headerValue(headers []*gmail.MessagePartHeader, name string) string {
+	for _, h := range headers {
+		if h.Name == name {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// attachmentsSizeOf sums part.Body.Size for every part (recursively,
+// since multipart/mixed parts can nest) that carries a Filename, i.e.
+// every attachment.
+func attachmentsSizeOf(part *gmail.MessagePart) int64 {
+	var total int64
+	if part.Filename != "" && part.Body != nil {
+		total += part.Body.Size
+	}
+	for _, child := range part.Parts {
+		total += attachmentsSizeOf(child)
+	}
+	return total
+}
+
+// CurrentHistoryId fetches the account's current historyId, which can be
+// used as the starting point for the next SyncHistory call.
+func (s *gmailStore) CurrentHistoryId() (uint64, error) {
+	profile, err := s.srv.Users.GetProfile(s.user).Do()
+	if err != nil {
+		return 0, err
+	}
+	return profile.HistoryId, nil
+}
+
+// SyncHistory implements mstore.HistorySyncer using Users.History.List.
+func (s *gmailStore) SyncHistory(startHistoryId uint64) ([]HistoryEvent, uint64, error) {
+	var events []HistoryEvent
+	newHistoryId := startHistoryId
+	call := s.srv.Users.History.List(s.user).StartHistoryId(startHistoryId)
+	for {
+		r, err := call.Do()
+		if err != nil {
+			if strings.Contains(err.Error(), "Error 404") {
+				return nil, 0, ErrHistoryExpired
+			}
+			return nil, 0, err
+		}
+		if r.HistoryId > newHistoryId {
+			newHistoryId = r.HistoryId
+		}
+		for _, h := range r.History {
+			for _, m := range h.MessagesAdded {
+				events = append(events, HistoryEvent{MessageId: m.Message.Id, LabelIds: m.Message.LabelIds})
+			}
+			for _, m := range h.MessagesDeleted {
+				events = append(events, HistoryEvent{MessageId: m.Message.Id, Deleted: true})
+			}
+			for _, m := range h.LabelsAdded {
+				events = append(events, HistoryEvent{MessageId: m.Message.Id, LabelIds: m.Message.LabelIds})
+			}
+			for _, m := range h.LabelsRemoved {
+				events = append(events, HistoryEvent{MessageId: m.Message.Id, LabelIds: m.Message.LabelIds})
+			}
+		}
+		if r.NextPageToken == "" {
+			break
+		}
+		call = s.srv.Users.History.List(s.user).StartHistoryId(startHistoryId).PageToken(r.NextPageToken)
+	}
+	return events, newHistoryId, nil
+}
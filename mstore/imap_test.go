@@ -0,0 +1,32 @@
+package mstore
+
+import "testing"
+
+func TestEncodeDecodeId(t *testing.T) {
+	cases := []struct {
+		mailbox string
+		uid     uint32
+	}{
+		{"INBOX", 1},
+		{"Archive/2024", 12345},
+		{"a/b", 0},
+	}
+	for _, c := range cases {
+		id := encodeId(c.mailbox, c.uid)
+		mailbox, uid, err := decodeId(id)
+		if err != nil {
+			t.Fatalf("decodeId(%q) returned error: %v", id, err)
+		}
+		if mailbox != c.mailbox || uid != c.uid {
+			t.Errorf("decodeId(%q) = (%q, %d), want (%q, %d)", id, mailbox, uid, c.mailbox, c.uid)
+		}
+	}
+}
+
+func TestDecodeIdInvalid(t *testing.T) {
+	for _, id := range []string{"", "no-slash", "mailbox/not-a-number"} {
+		if _, _, err := decodeId(id); err == nil {
+			t.Errorf("decodeId(%q): expected error, got nil", id)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package mstore defines a backend-agnostic interface for enumerating
+// mailboxes (labels) and messages, so that the importer/processor code
+// doesn't need to know whether it is talking to the Gmail REST API or
+// to a plain IMAP server.
+package mstore
+
+import (
+	"errors"
+	"time"
+)
+
+// Label is a mailbox/label as reported by the underlying store.
+type Label struct {
+	Id   string
+	Name string
+}
+
+// MessageHeader is the minimal per-message data needed to queue it for
+// processing (the Gmail message id or, for IMAP, the mailbox/UID pair
+// encoded as a single opaque string).
+type MessageHeader struct {
+	Id string
+}
+
+// MessageInfo is the data collected for a single message once it has
+// been fetched from the store.
+type MessageInfo struct {
+	Id              string
+	LabelIds        []string
+	SizeEstimate    int64
+	InternalDate    time.Time
+	From            string
+	ThreadId        string
+	AttachmentsSize int64
+}
+
+// MessageStore is implemented by each ingestion backend (Gmail REST API,
+// IMAP, ...). importLabels, importMessages and processMessages depend on
+// this interface rather than on a concrete client.
+type MessageStore interface {
+	// ListLabels returns every label/mailbox known to the account.
+	ListLabels() ([]Label, error)
+	// ListMessageIds returns the ids of every message newer than
+	// afterDate (formatted as "YYYY/M/D", matching Gmail's search
+	// syntax), across all mailboxes.
+	ListMessageIds(afterDate string) ([]MessageHeader, error)
+	// GetMessage fetches the size, labels and internal date of a
+	// single message.
+	GetMessage(id string) (*MessageInfo, error)
+}
+
+// HistoryEvent is a single change reported by HistorySyncer.SyncHistory:
+// either a message that was added/relabeled (Deleted == false, in which
+// case LabelIds holds its current labels) or removed (Deleted == true).
+type HistoryEvent struct {
+	MessageId string
+	LabelIds  []string
+	Deleted   bool
+}
+
+// ErrHistoryExpired is returned by SyncHistory when the Gmail history id
+// it was given is older than the server's retention window and a full
+// resync is required.
+var ErrHistoryExpired = errors.New("history id expired, full resync required")
+
+// NotFound is implemented by store errors that mean the requested
+// message no longer exists upstream (e.g. it was deleted between
+// listing and fetching). Stores that can't tell "gone" apart from other
+// failures don't need to implement it. See IsNotFound.
+type NotFound interface {
+	NotFound() bool
+}
+
+// IsNotFound reports whether err indicates a message no longer exists
+// upstream, regardless of which MessageStore produced it.
+func IsNotFound(err error) bool {
+	nf, ok := err.(NotFound)
+	return ok && nf.NotFound()
+}
+
+// Retryable is implemented by store errors worth retrying with backoff
+// (rate limits, transient network/server failures). See IsRetryable.
+type Retryable interface {
+	Retryable() bool
+}
+
+// IsRetryable reports whether err is worth retrying with backoff,
+// regardless of which MessageStore produced it.
+func IsRetryable(err error) bool {
+	r, ok := err.(Retryable)
+	return ok && r.Retryable()
+}
+
+// HistorySyncer is an optional capability of a MessageStore that supports
+// incremental sync via a persisted cursor (Gmail's historyId). Stores
+// that can't offer this - like plain IMAP, which has no equivalent of
+// the History API - simply don't implement it; callers should type-assert
+// for it and fall back to a full ListMessageIds scan otherwise.
+type HistorySyncer interface {
+	// CurrentHistoryId returns the history id to use as a starting
+	// point for the next call to SyncHistory.
+	CurrentHistoryId() (uint64, error)
+	// SyncHistory returns every MessagesAdded/MessagesDeleted/
+	// LabelAdded/LabelRemoved event since startHistoryId, and the
+	// history id to persist for the next call. Returns
+	// ErrHistoryExpired if startHistoryId is too old for the server
+	// to have retained.
+	SyncHistory(startHistoryId uint64) (events []HistoryEvent, newHistoryId uint64, err error)
+}
@@ -0,0 +1,217 @@
+// Package report turns the aggregates exposed by storage.Backend into the
+// "--report" output the command line tool prints: a table of rows with a
+// header, renderable as a plain table, CSV, or JSON.
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Labutin/GMailMessagesSize/storage"
+)
+
+// Table is a generic header + rows result, shared by every report kind so
+// they can all go through the same Write function.
+type Table struct {
+	Header []string
+	Rows   [][]string
+}
+
+// Run builds the report named kind against backend (topN only applies to
+// "threads-top"; labelIds restricts the "labels" report to a single
+// combined row for those label ids, matching the tool's original -l flag)
+// and writes it to out in the given format.
+func Run(backend storage.Backend, kind string, format string, topN int, labelIds []string, out io.Writer) error {
+	table, err := build(backend, kind, topN, labelIds)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "table":
+		return writeTable(table, out)
+	case "csv":
+		return writeCsv(table, out)
+	case "json":
+		return writeJson(table, out)
+	default:
+		return fmt.Errorf("unknown format: %s. Expected table, csv or json", format)
+	}
+}
+
+func build(backend storage.Backend, kind string, topN int, labelIds []string) (*Table, error) {
+	switch kind {
+	case "labels":
+		return buildLabels(backend, labelIds)
+	case "senders":
+		return buildSenders(backend)
+	case "attachments":
+		return buildAttachments(backend)
+	case "threads-top":
+		return buildTopThreads(backend, topN)
+	case "histogram":
+		return buildHistogram(backend)
+	case "timeseries":
+		return buildTimeSeries(backend)
+	default:
+		return nil, fmt.Errorf("unknown report: %s. Expected labels, senders, attachments, threads-top, histogram or timeseries", kind)
+	}
+}
+
+func buildLabels(backend storage.Backend, labelIds []string) (*Table, error) {
+	labels, err := backend.ListLabels()
+	if err != nil {
+		return nil, fmt.Errorf("can't get labels list: %v", err)
+	}
+	table := &Table{Header: []string{"LabelId", "Label name", "Messages size", "Messages count"}}
+	if len(labelIds) > 0 {
+		row, err := labelRow(backend, labels, labelIds)
+		if err != nil {
+			return nil, err
+		}
+		table.Rows = append(table.Rows, row)
+		return table, nil
+	}
+	for _, l := range labels {
+		row, err := labelRow(backend, labels, []string{l.Id})
+		if err != nil {
+			return nil, err
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, nil
+}
+
+// labelRow aggregates size/count for ids (all of them, see
+// storage.Backend.AggregateBySizeByLabel) into one "ids;names;size;count"
+// row, looking up names among known.
+func labelRow(backend storage.Backend, known []storage.Label, ids []string) ([]string, error) {
+	var names []string
+	for _, id := range ids {
+		for _, l := range known {
+			if l.Id == id {
+				names = append(names, l.Name)
+				break
+			}
+		}
+	}
+	sum, count, err := backend.AggregateBySizeByLabel(ids)
+	if err != nil {
+		return nil, fmt.Errorf("can't calculate label size: %v", err)
+	}
+	return []string{strings.Join(ids, ","), strings.Join(names, ","), strconv.FormatInt(sum, 10), strconv.FormatInt(count, 10)}, nil
+}
+
+func buildSenders(backend storage.Backend) (*Table, error) {
+	senders, err := backend.AggregateBySender()
+	if err != nil {
+		return nil, fmt.Errorf("can't aggregate by sender: %v", err)
+	}
+	table := &Table{Header: []string{"From", "Messages size", "Messages count"}}
+	for _, s := range senders {
+		table.Rows = append(table.Rows, []string{s.From, strconv.FormatInt(s.Size, 10), strconv.FormatInt(s.Count, 10)})
+	}
+	return table, nil
+}
+
+func buildAttachments(backend storage.Backend) (*Table, error) {
+	sum, count, err := backend.TotalAttachmentsSize()
+	if err != nil {
+		return nil, fmt.Errorf("can't aggregate attachments size: %v", err)
+	}
+	table := &Table{Header: []string{"Attachments size", "Messages with attachments"}}
+	table.Rows = append(table.Rows, []string{strconv.FormatInt(sum, 10), strconv.FormatInt(count, 10)})
+	return table, nil
+}
+
+func buildTopThreads(backend storage.Backend, topN int) (*Table, error) {
+	threads, err := backend.TopThreads(topN)
+	if err != nil {
+		return nil, fmt.Errorf("can't get top threads: %v", err)
+	}
+	table := &Table{Header: []string{"ThreadId", "Messages size", "Messages count"}}
+	for _, t := range threads {
+		table.Rows = append(table.Rows, []string{t.ThreadId, strconv.FormatInt(t.Size, 10), strconv.FormatInt(t.Count, 10)})
+	}
+	return table, nil
+}
+
+func buildHistogram(backend storage.Backend) (*Table, error) {
+	buckets, err := backend.SizeHistogram()
+	if err != nil {
+		return nil, fmt.Errorf("can't build size histogram: %v", err)
+	}
+	table := &Table{Header: []string{"Bucket", "Messages count"}}
+	for _, b := range buckets {
+		table.Rows = append(table.Rows, []string{b.Bucket, strconv.FormatInt(b.Count, 10)})
+	}
+	return table, nil
+}
+
+func buildTimeSeries(backend storage.Backend) (*Table, error) {
+	points, err := backend.SizeTimeSeries()
+	if err != nil {
+		return nil, fmt.Errorf("can't build size timeseries: %v", err)
+	}
+	table := &Table{Header: []string{"Month", "Bytes"}}
+	for _, p := range points {
+		table.Rows = append(table.Rows, []string{p.Month, strconv.FormatInt(p.Bytes, 10)})
+	}
+	return table, nil
+}
+
+// writeTable renders the report as a tab-aligned table, the closest
+// equivalent to the tool's original ";"-separated stdout output.
+func writeTable(table *Table, out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, joinTab(table.Header))
+	for _, row := range table.Rows {
+		fmt.Fprintln(w, joinTab(row))
+	}
+	return w.Flush()
+}
+
+func joinTab(fields []string) string {
+	s := ""
+	for i, f := range fields {
+		if i > 0 {
+			s += "\t"
+		}
+		s += f
+	}
+	return s
+}
+
+func writeCsv(table *Table, out io.Writer) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(table.Header); err != nil {
+		return err
+	}
+	for _, row := range table.Rows {
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeJson(table *Table, out io.Writer) error {
+	records := make([]map[string]string, 0, len(table.Rows))
+	for _, row := range table.Rows {
+		record := make(map[string]string, len(table.Header))
+		for i, h := range table.Header {
+			if i < len(row) {
+				record[h] = row[i]
+			}
+		}
+		records = append(records, record)
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
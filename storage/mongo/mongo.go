@@ -0,0 +1,379 @@
+// Package mongo implements storage.Backend on top of MongoDB via mgo.v2.
+// This is the original storage layer the tool shipped with - it now just
+// sits behind the storage.Backend interface alongside storage/sqlite and
+// storage/postgres, with RequeueMessage added for the daemon's
+// incremental resync.
+package mongo
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/Labutin/GMailMessagesSize/storage"
+)
+
+const database string = "gmail"
+const labelCollection string = "labels"
+const messageCollection string = "messages"
+const syncStateCollection string = "sync_state"
+
+type label struct {
+	Id   string
+	Name string
+}
+
+type message struct {
+	Id              string    `bson:"id"`
+	LabelIds        []string  `bson:"labelids"`
+	Processed       bool      `bson:"processed"`
+	SizeEstimate    int64     `bson:"sizeestimate"`
+	InternalDate    time.Time `bson:"internaldate"`
+	From            string    `bson:"from"`
+	ThreadId        string    `bson:"threadid"`
+	AttachmentsSize int64     `bson:"attachmentssize"`
+}
+
+type syncState struct {
+	Id        string `bson:"id"`
+	HistoryId uint64 `bson:"historyid"`
+}
+
+// backend implements storage.Backend against a MongoDB session.
+type backend struct {
+	session *mgo.Session
+}
+
+// New dials connectionString and ensures the required collections and
+// indexes exist.
+func New(connectionString string) (storage.Backend, error) {
+	session, err := mgo.Dial(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	b := &backend{session: session}
+	if err := b.ensureMessageIndexes(); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *backend) labels() *mgo.Collection {
+	return b.session.DB(database).C(labelCollection)
+}
+
+func (b *backend) messages() *mgo.Collection {
+	return b.session.DB(database).C(messageCollection)
+}
+
+func (b *backend) syncStateCol() *mgo.Collection {
+	return b.session.DB(database).C(syncStateCollection)
+}
+
+// ensureMessageIndexes creates collection "messages" in "gmail" database
+// and creates indexes
+func (b *backend) ensureMessageIndexes() error {
+	mc := b.messages()
+	index := mgo.Index{Key: []string{"id"}, Unique: true}
+	if err := mc.EnsureIndex(index); err != nil {
+		return err
+	}
+	index = mgo.Index{Key: []string{"processed"}}
+	if err := mc.EnsureIndex(index); err != nil {
+		return err
+	}
+	index = mgo.Index{Key: []string{"internaldate"}}
+	if err := mc.EnsureIndex(index); err != nil {
+		return err
+	}
+	index = mgo.Index{Key: []string{"labelids"}}
+	return mc.EnsureIndex(index)
+}
+
+// ResetLabels drops and recreates the "labels" collection and its index.
+func (b *backend) ResetLabels() error {
+	lc := b.labels()
+	lc.DropCollection()
+	index := mgo.Index{Key: []string{"id"}, Unique: true}
+	return lc.EnsureIndex(index)
+}
+
+func (b *backend) UpsertLabel(l storage.Label) error {
+	return b.labels().Insert(&label{Id: l.Id, Name: l.Name})
+}
+
+func (b *backend) ListLabels() ([]storage.Label, error) {
+	var labels []label
+	if err := b.labels().Find(nil).Sort("name").All(&labels); err != nil {
+		return nil, err
+	}
+	result := make([]storage.Label, 0, len(labels))
+	for _, l := range labels {
+		result = append(result, storage.Label{Id: l.Id, Name: l.Name})
+	}
+	return result, nil
+}
+
+func (b *backend) UpsertMessage(id string) error {
+	_, err := b.messages().Upsert(bson.M{"id": id}, bson.M{
+		"$setOnInsert": bson.M{
+			"processed":    false,
+			"internaldate": time.Date(1900, time.January, 1, 1, 0, 0, 0, time.UTC),
+		},
+	})
+	return err
+}
+
+func (b *backend) RequeueMessage(id string) error {
+	_, err := b.messages().Upsert(bson.M{"id": id}, bson.M{
+		"$set":         bson.M{"processed": false},
+		"$setOnInsert": bson.M{"internaldate": time.Date(1900, time.January, 1, 1, 0, 0, 0, time.UTC)},
+	})
+	return err
+}
+
+func (b *backend) RemoveMessage(id string) error {
+	err := b.messages().Remove(bson.M{"id": id})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (b *backend) MarkProcessed(id string, info storage.Message) error {
+	return b.messages().Update(bson.M{"id": id}, bson.M{"$set": bson.M{
+		"processed":       true,
+		"sizeestimate":    info.SizeEstimate,
+		"labelids":        info.LabelIds,
+		"internaldate":    info.InternalDate,
+		"from":            info.From,
+		"threadid":        info.ThreadId,
+		"attachmentssize": info.AttachmentsSize,
+	}})
+}
+
+func (b *backend) FindUnprocessed(limit int) ([]storage.Message, error) {
+	var messages []message
+	err := b.messages().Find(bson.M{"processed": false}).Limit(limit).All(&messages)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]storage.Message, 0, len(messages))
+	for _, m := range messages {
+		result = append(result, toStorageMessage(m))
+	}
+	return result, nil
+}
+
+func toStorageMessage(m message) storage.Message {
+	return storage.Message{
+		Id:              m.Id,
+		LabelIds:        m.LabelIds,
+		Processed:       m.Processed,
+		SizeEstimate:    m.SizeEstimate,
+		InternalDate:    m.InternalDate,
+		From:            m.From,
+		ThreadId:        m.ThreadId,
+		AttachmentsSize: m.AttachmentsSize,
+	}
+}
+
+func (b *backend) LastInternalDate() (time.Time, error) {
+	last := new(message)
+	err := b.messages().Find(nil).Sort("-internaldate").One(last)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return time.Date(1900, time.January, 1, 1, 0, 0, 0, time.UTC), nil
+		}
+		return time.Time{}, err
+	}
+	return last.InternalDate, nil
+}
+
+func (b *backend) AggregateBySizeByLabel(labelIds []string) (int64, int64, error) {
+	lIdsQuery := bson.M{}
+	if len(labelIds) == 1 {
+		lIdsQuery = bson.M{"$in": labelIds}
+	} else {
+		lIdsQuery = bson.M{"$all": labelIds, "$size": len(labelIds)}
+	}
+	res := bson.M{}
+	err := b.messages().Pipe([]bson.M{
+		{"$match": bson.M{"labelids": lIdsQuery}},
+		{"$group": bson.M{"_id": nil,
+			"sum":   bson.M{"$sum": "$sizeestimate"},
+			"count": bson.M{"$sum": 1}}}}).One(&res)
+	if err != nil {
+		if err.Error() == "not found" {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	return toInt64(res["sum"]), toInt64(res["count"]), nil
+}
+
+func (b *backend) AggregateBySender() ([]storage.SenderSize, error) {
+	var rows []struct {
+		From  string `bson:"_id"`
+		Sum   int64  `bson:"sum"`
+		Count int64  `bson:"count"`
+	}
+	err := b.messages().Pipe([]bson.M{
+		{"$match": bson.M{"processed": true}},
+		{"$group": bson.M{"_id": "$from",
+			"sum":   bson.M{"$sum": "$sizeestimate"},
+			"count": bson.M{"$sum": 1}}},
+		{"$sort": bson.M{"sum": -1}},
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]storage.SenderSize, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, storage.SenderSize{From: r.From, Size: r.Sum, Count: r.Count})
+	}
+	return result, nil
+}
+
+func (b *backend) TotalAttachmentsSize() (int64, int64, error) {
+	res := bson.M{}
+	err := b.messages().Pipe([]bson.M{
+		{"$match": bson.M{"attachmentssize": bson.M{"$gt": 0}}},
+		{"$group": bson.M{"_id": nil,
+			"sum":   bson.M{"$sum": "$attachmentssize"},
+			"count": bson.M{"$sum": 1}}},
+	}).One(&res)
+	if err != nil {
+		if err.Error() == "not found" {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	return toInt64(res["sum"]), toInt64(res["count"]), nil
+}
+
+func (b *backend) TopThreads(n int) ([]storage.ThreadSize, error) {
+	var rows []struct {
+		ThreadId string `bson:"_id"`
+		Sum      int64  `bson:"sum"`
+		Count    int64  `bson:"count"`
+	}
+	err := b.messages().Pipe([]bson.M{
+		{"$match": bson.M{"processed": true, "threadid": bson.M{"$ne": ""}}},
+		{"$group": bson.M{"_id": "$threadid",
+			"sum":   bson.M{"$sum": "$sizeestimate"},
+			"count": bson.M{"$sum": 1}}},
+		{"$sort": bson.M{"sum": -1}},
+		{"$limit": n},
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]storage.ThreadSize, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, storage.ThreadSize{ThreadId: r.ThreadId, Size: r.Sum, Count: r.Count})
+	}
+	return result, nil
+}
+
+// histogramBucketBounds are the upper bounds (in bytes) of every bucket
+// but the last, which catches everything above histogramBucketBounds[len-1].
+var histogramBucketBounds = []int64{10 * 1024, 100 * 1024, 1024 * 1024, 10 * 1024 * 1024}
+var histogramBucketLabels = []string{"<10KB", "10-100KB", "100KB-1MB", "1-10MB", ">10MB"}
+
+func (b *backend) SizeHistogram() ([]storage.HistogramBucket, error) {
+	buckets := make([]storage.HistogramBucket, len(histogramBucketLabels))
+	for i, label := range histogramBucketLabels {
+		buckets[i] = storage.HistogramBucket{Bucket: label}
+	}
+	var rows []struct {
+		Id    int64 `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	boundaries := append(append([]int64{0}, histogramBucketBounds...), 1<<62)
+	err := b.messages().Pipe([]bson.M{
+		{"$match": bson.M{"processed": true}},
+		{"$bucket": bson.M{
+			"groupBy":    "$sizeestimate",
+			"boundaries": boundaries,
+			"default":    "other",
+			"output":     bson.M{"count": bson.M{"$sum": 1}},
+		}},
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		for i, lower := range boundaries[:len(boundaries)-1] {
+			if r.Id == lower {
+				buckets[i].Count = r.Count
+				break
+			}
+		}
+	}
+	return buckets, nil
+}
+
+func (b *backend) SizeTimeSeries() ([]storage.TimeSeriesPoint, error) {
+	var rows []struct {
+		Id    string `bson:"_id"`
+		Bytes int64  `bson:"bytes"`
+	}
+	err := b.messages().Pipe([]bson.M{
+		{"$match": bson.M{"processed": true}},
+		{"$group": bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$internaldate"}},
+			"bytes": bson.M{"$sum": "$sizeestimate"},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]storage.TimeSeriesPoint, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, storage.TimeSeriesPoint{Month: r.Id, Bytes: r.Bytes})
+	}
+	return result, nil
+}
+
+// toInt64 normalizes the numeric types mgo may decode a $sum/$group
+// result into (int, int32, int64, float64) to a plain int64.
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	default:
+		return 0
+	}
+}
+
+func (b *backend) GetSyncState() (uint64, error) {
+	state := &syncState{}
+	err := b.syncStateCol().Find(bson.M{"id": "state"}).One(state)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return state.HistoryId, nil
+}
+
+func (b *backend) SaveSyncState(historyId uint64) error {
+	_, err := b.syncStateCol().Upsert(bson.M{"id": "state"}, bson.M{"$set": bson.M{"id": "state", "historyid": historyId}})
+	return err
+}
+
+func (b *backend) Close() error {
+	b.session.Close()
+	return nil
+}
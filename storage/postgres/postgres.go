@@ -0,0 +1,305 @@
+// Package postgres implements storage.Backend on top of an existing
+// PostgreSQL instance via github.com/lib/pq, for users who already run
+// Postgres and don't want to stand up MongoDB just for this tool.
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/Labutin/GMailMessagesSize/storage"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS labels (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS messages (
+	id TEXT PRIMARY KEY,
+	processed BOOLEAN NOT NULL DEFAULT FALSE,
+	size_estimate BIGINT NOT NULL DEFAULT 0,
+	internal_date TIMESTAMPTZ NOT NULL,
+	sender TEXT NOT NULL DEFAULT '',
+	thread_id TEXT NOT NULL DEFAULT '',
+	attachments_size BIGINT NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS message_labels (
+	message_id TEXT NOT NULL,
+	label_id TEXT NOT NULL,
+	PRIMARY KEY (message_id, label_id)
+);
+CREATE TABLE IF NOT EXISTS sync_state (
+	id TEXT PRIMARY KEY,
+	history_id BIGINT NOT NULL
+);
+`
+
+// backend implements storage.Backend against a PostgreSQL database.
+type backend struct {
+	db *sql.DB
+}
+
+// New connects to the Postgres instance described by dsn (a standard
+// "postgres://..." connection string) and ensures the schema exists.
+func New(dsn string) (storage.Backend, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &backend{db: db}, nil
+}
+
+func (b *backend) ResetLabels() error {
+	_, err := b.db.Exec("DELETE FROM labels")
+	return err
+}
+
+func (b *backend) UpsertLabel(l storage.Label) error {
+	_, err := b.db.Exec(
+		"INSERT INTO labels (id, name) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET name = excluded.name",
+		l.Id, l.Name)
+	return err
+}
+
+func (b *backend) ListLabels() ([]storage.Label, error) {
+	rows, err := b.db.Query("SELECT id, name FROM labels ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var labels []storage.Label
+	for rows.Next() {
+		var l storage.Label
+		if err := rows.Scan(&l.Id, &l.Name); err != nil {
+			return nil, err
+		}
+		labels = append(labels, l)
+	}
+	return labels, rows.Err()
+}
+
+func (b *backend) UpsertMessage(id string) error {
+	_, err := b.db.Exec(
+		"INSERT INTO messages (id, processed, size_estimate, internal_date) VALUES ($1, FALSE, 0, $2) ON CONFLICT (id) DO NOTHING",
+		id, time.Date(1900, time.January, 1, 1, 0, 0, 0, time.UTC))
+	return err
+}
+
+func (b *backend) RequeueMessage(id string) error {
+	_, err := b.db.Exec(
+		"INSERT INTO messages (id, processed, size_estimate, internal_date) VALUES ($1, FALSE, 0, $2) ON CONFLICT (id) DO UPDATE SET processed = FALSE",
+		id, time.Date(1900, time.January, 1, 1, 0, 0, 0, time.UTC))
+	return err
+}
+
+func (b *backend) RemoveMessage(id string) error {
+	if _, err := b.db.Exec("DELETE FROM message_labels WHERE message_id = $1", id); err != nil {
+		return err
+	}
+	_, err := b.db.Exec("DELETE FROM messages WHERE id = $1", id)
+	return err
+}
+
+func (b *backend) MarkProcessed(id string, info storage.Message) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"UPDATE messages SET processed = TRUE, size_estimate = $1, internal_date = $2, sender = $3, thread_id = $4, attachments_size = $5 WHERE id = $6",
+		info.SizeEstimate, info.InternalDate, info.From, info.ThreadId, info.AttachmentsSize, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM message_labels WHERE message_id = $1", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, labelId := range info.LabelIds {
+		if _, err := tx.Exec("INSERT INTO message_labels (message_id, label_id) VALUES ($1, $2)", id, labelId); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (b *backend) FindUnprocessed(limit int) ([]storage.Message, error) {
+	rows, err := b.db.Query("SELECT id FROM messages WHERE processed = FALSE LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var messages []storage.Message
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		messages = append(messages, storage.Message{Id: id})
+	}
+	return messages, rows.Err()
+}
+
+func (b *backend) LastInternalDate() (time.Time, error) {
+	var t time.Time
+	err := b.db.QueryRow("SELECT internal_date FROM messages ORDER BY internal_date DESC LIMIT 1").Scan(&t)
+	if err == sql.ErrNoRows {
+		return time.Date(1900, time.January, 1, 1, 0, 0, 0, time.UTC), nil
+	}
+	return t, err
+}
+
+func (b *backend) AggregateBySizeByLabel(labelIds []string) (int64, int64, error) {
+	if len(labelIds) == 0 {
+		return 0, 0, nil
+	}
+	placeholders := ""
+	args := make([]interface{}, 0, len(labelIds)+2)
+	for i, id := range labelIds {
+		if i > 0 {
+			placeholders += ", "
+		}
+		placeholders += fmt.Sprintf("$%d", i+1)
+		args = append(args, id)
+	}
+	var query string
+	if len(labelIds) == 1 {
+		query = `SELECT COALESCE(SUM(m.size_estimate), 0), COUNT(*)
+			FROM messages m
+			JOIN message_labels ml ON ml.message_id = m.id
+			WHERE ml.label_id IN (` + placeholders + `)`
+	} else {
+		n := len(labelIds)
+		args = append(args, n, n)
+		query = fmt.Sprintf(`SELECT COALESCE(SUM(m.size_estimate), 0), COUNT(*)
+			FROM messages m
+			WHERE (SELECT COUNT(*) FROM message_labels ml WHERE ml.message_id = m.id AND ml.label_id IN (%s)) = $%d
+			AND (SELECT COUNT(*) FROM message_labels ml2 WHERE ml2.message_id = m.id) = $%d`,
+			placeholders, n+1, n+2)
+	}
+	var sum, count int64
+	err := b.db.QueryRow(query, args...).Scan(&sum, &count)
+	return sum, count, err
+}
+
+func (b *backend) AggregateBySender() ([]storage.SenderSize, error) {
+	rows, err := b.db.Query(`SELECT sender, COALESCE(SUM(size_estimate), 0), COUNT(*)
+		FROM messages WHERE processed = TRUE GROUP BY sender ORDER BY 2 DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []storage.SenderSize
+	for rows.Next() {
+		var s storage.SenderSize
+		if err := rows.Scan(&s.From, &s.Size, &s.Count); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+func (b *backend) TotalAttachmentsSize() (int64, int64, error) {
+	var sum, count int64
+	err := b.db.QueryRow(`SELECT COALESCE(SUM(attachments_size), 0), COUNT(*)
+		FROM messages WHERE attachments_size > 0`).Scan(&sum, &count)
+	return sum, count, err
+}
+
+func (b *backend) TopThreads(n int) ([]storage.ThreadSize, error) {
+	rows, err := b.db.Query(`SELECT thread_id, COALESCE(SUM(size_estimate), 0), COUNT(*)
+		FROM messages WHERE processed = TRUE AND thread_id != ''
+		GROUP BY thread_id ORDER BY 2 DESC LIMIT $1`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []storage.ThreadSize
+	for rows.Next() {
+		var t storage.ThreadSize
+		if err := rows.Scan(&t.ThreadId, &t.Size, &t.Count); err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
+
+var histogramBuckets = []struct {
+	label string
+	lower int64
+	upper int64
+}{
+	{"<10KB", 0, 10 * 1024},
+	{"10-100KB", 10 * 1024, 100 * 1024},
+	{"100KB-1MB", 100 * 1024, 1024 * 1024},
+	{"1-10MB", 1024 * 1024, 10 * 1024 * 1024},
+	{">10MB", 10 * 1024 * 1024, 1<<62 - 1},
+}
+
+func (b *backend) SizeHistogram() ([]storage.HistogramBucket, error) {
+	result := make([]storage.HistogramBucket, len(histogramBuckets))
+	for i, hb := range histogramBuckets {
+		result[i].Bucket = hb.label
+		err := b.db.QueryRow(
+			"SELECT COUNT(*) FROM messages WHERE processed = TRUE AND size_estimate >= $1 AND size_estimate < $2",
+			hb.lower, hb.upper).Scan(&result[i].Count)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (b *backend) SizeTimeSeries() ([]storage.TimeSeriesPoint, error) {
+	rows, err := b.db.Query(`SELECT to_char(internal_date, 'YYYY-MM'), COALESCE(SUM(size_estimate), 0)
+		FROM messages WHERE processed = TRUE GROUP BY 1 ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []storage.TimeSeriesPoint
+	for rows.Next() {
+		var p storage.TimeSeriesPoint
+		if err := rows.Scan(&p.Month, &p.Bytes); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+func (b *backend) GetSyncState() (uint64, error) {
+	var historyId uint64
+	err := b.db.QueryRow("SELECT history_id FROM sync_state WHERE id = 'state'").Scan(&historyId)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return historyId, err
+}
+
+func (b *backend) SaveSyncState(historyId uint64) error {
+	_, err := b.db.Exec(
+		"INSERT INTO sync_state (id, history_id) VALUES ('state', $1) ON CONFLICT (id) DO UPDATE SET history_id = excluded.history_id",
+		historyId)
+	return err
+}
+
+func (b *backend) Close() error {
+	return b.db.Close()
+}
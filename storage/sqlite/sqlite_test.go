@@ -0,0 +1,61 @@
+package sqlite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Labutin/GMailMessagesSize/storage"
+)
+
+func newTestBackend(t *testing.T) storage.Backend {
+	t.Helper()
+	b, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func seedMessage(t *testing.T, b storage.Backend, id string, size int64, labelIds []string) {
+	t.Helper()
+	if err := b.UpsertMessage(id); err != nil {
+		t.Fatalf("UpsertMessage(%s): %v", id, err)
+	}
+	if err := b.MarkProcessed(id, storage.Message{
+		SizeEstimate: size,
+		LabelIds:     labelIds,
+		InternalDate: time.Now(),
+	}); err != nil {
+		t.Fatalf("MarkProcessed(%s): %v", id, err)
+	}
+}
+
+// TestAggregateBySizeByLabel checks the SQL rewrite of the Mongo
+// $all+$size aggregation: a single label matches any message carrying
+// it, while more than one label matches only messages carrying exactly
+// that set.
+func TestAggregateBySizeByLabel(t *testing.T) {
+	b := newTestBackend(t)
+	seedMessage(t, b, "m1", 100, []string{"INBOX"})
+	seedMessage(t, b, "m2", 200, []string{"INBOX", "IMPORTANT"})
+	seedMessage(t, b, "m3", 300, []string{"IMPORTANT"})
+
+	if sum, count, err := b.AggregateBySizeByLabel([]string{"INBOX"}); err != nil {
+		t.Fatalf("AggregateBySizeByLabel([INBOX]): %v", err)
+	} else if sum != 300 || count != 2 {
+		t.Errorf("AggregateBySizeByLabel([INBOX]) = (%d, %d), want (300, 2)", sum, count)
+	}
+
+	if sum, count, err := b.AggregateBySizeByLabel([]string{"INBOX", "IMPORTANT"}); err != nil {
+		t.Fatalf("AggregateBySizeByLabel([INBOX, IMPORTANT]): %v", err)
+	} else if sum != 200 || count != 1 {
+		t.Errorf("AggregateBySizeByLabel([INBOX, IMPORTANT]) = (%d, %d), want (200, 1)", sum, count)
+	}
+
+	if sum, count, err := b.AggregateBySizeByLabel([]string{"NONE"}); err != nil {
+		t.Fatalf("AggregateBySizeByLabel([NONE]): %v", err)
+	} else if sum != 0 || count != 0 {
+		t.Errorf("AggregateBySizeByLabel([NONE]) = (%d, %d), want (0, 0)", sum, count)
+	}
+}
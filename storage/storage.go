@@ -0,0 +1,122 @@
+// Package storage defines the persistence interface used to track
+// labels, messages and sync state, so the tool isn't hard-wired to
+// MongoDB. Concrete implementations live in storage/mongo,
+// storage/sqlite and storage/postgres.
+package storage
+
+import "time"
+
+// Label mirrors a Gmail label / IMAP mailbox.
+type Label struct {
+	Id   string
+	Name string
+}
+
+// Message is a queued/processed message as tracked by a Backend.
+type Message struct {
+	Id              string
+	LabelIds        []string
+	Processed       bool
+	SizeEstimate    int64
+	InternalDate    time.Time
+	From            string
+	ThreadId        string
+	AttachmentsSize int64
+}
+
+// SenderSize is one row of the "senders" report: total size/count of
+// messages From a given sender.
+type SenderSize struct {
+	From  string
+	Size  int64
+	Count int64
+}
+
+// ThreadSize is one row of the "threads-top" report.
+type ThreadSize struct {
+	ThreadId string
+	Size     int64
+	Count    int64
+}
+
+// HistogramBucket is one row of the "histogram" report: how many
+// messages fall in a given size range.
+type HistogramBucket struct {
+	Bucket string
+	Count  int64
+}
+
+// TimeSeriesPoint is one row of the "timeseries" report: total bytes for
+// messages received in a given month.
+type TimeSeriesPoint struct {
+	Month string
+	Bytes int64
+}
+
+// Backend is implemented by each storage engine (Mongo, SQLite,
+// Postgres). importLabels, importMessages, processMessages and
+// report.Run depend on this interface rather than on a concrete
+// database driver.
+type Backend interface {
+	// ResetLabels clears out all known labels; called at the start of
+	// a fresh --importLabels run.
+	ResetLabels() error
+	// UpsertLabel records a single label.
+	UpsertLabel(label Label) error
+	// ListLabels returns every known label, ordered by name.
+	ListLabels() ([]Label, error)
+
+	// UpsertMessage queues a message id for processing if it isn't
+	// already known. It must be idempotent - called once per message
+	// id returned by a mstore.MessageStore scan - and must NOT reset
+	// Processed on a message that's already known, since the same id
+	// is re-seen on every scan of the rolling import window.
+	UpsertMessage(id string) error
+	// RequeueMessage queues a message id for (re)processing regardless
+	// of whether it's already known, resetting Processed to false if
+	// it was true. Used for history events that mean a known message's
+	// data is stale (e.g. a relabel) rather than a first sighting.
+	RequeueMessage(id string) error
+	// RemoveMessage removes a message, e.g. when the upstream source
+	// reports it as deleted or not found.
+	RemoveMessage(id string) error
+	// MarkProcessed records the data collected for a message (by
+	// mstore.MessageStore.GetMessage) and marks it processed.
+	MarkProcessed(id string, info Message) error
+	// FindUnprocessed returns up to limit messages still awaiting
+	// MarkProcessed.
+	FindUnprocessed(limit int) ([]Message, error)
+	// LastInternalDate returns the InternalDate of the most recently
+	// seen message, or 1900-01-01 01:00 UTC if none has been imported yet.
+	LastInternalDate() (time.Time, error)
+	// AggregateBySizeByLabel sums SizeEstimate and counts messages
+	// carrying every id in labelIds (and, when len(labelIds) > 1,
+	// exactly those labels - matching the Mongo $all+$size behavior
+	// this replaces).
+	AggregateBySizeByLabel(labelIds []string) (sum int64, count int64, err error)
+	// AggregateBySender sums SizeEstimate and counts messages grouped
+	// by From, for the "senders" report.
+	AggregateBySender() ([]SenderSize, error)
+	// TotalAttachmentsSize sums AttachmentsSize and counts messages
+	// that have at least one named attachment part, for the
+	// "attachments" report.
+	TotalAttachmentsSize() (sum int64, count int64, err error)
+	// TopThreads returns the n threads with the largest total size, for
+	// the "threads-top" report.
+	TopThreads(n int) ([]ThreadSize, error)
+	// SizeHistogram buckets processed messages by size (<10KB,
+	// 10-100KB, 100KB-1MB, 1-10MB, >10MB), for the "histogram" report.
+	SizeHistogram() ([]HistogramBucket, error)
+	// SizeTimeSeries sums bytes per calendar month, for the
+	// "timeseries" report.
+	SizeTimeSeries() ([]TimeSeriesPoint, error)
+
+	// GetSyncState returns the persisted historyId cursor used for
+	// incremental daemon sync, or 0 if none has been saved yet.
+	GetSyncState() (uint64, error)
+	// SaveSyncState persists the historyId cursor.
+	SaveSyncState(historyId uint64) error
+
+	// Close releases any underlying connection/session.
+	Close() error
+}
@@ -0,0 +1,116 @@
+package tokenstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/oauth2"
+	"golang.org/x/term"
+)
+
+// Argon2id parameters for deriving the AES key from the user's
+// passphrase. Tuned for an interactive CLI tool, not a server handling
+// many logins per second.
+const (
+	argonMemoryKiB  = 64 * 1024
+	argonIterations = 3
+	argonThreads    = 2
+	argonKeyLen     = 32
+	saltLen         = 16
+)
+
+const passphraseEnvVar = "GMAIL_TOKEN_PASSPHRASE"
+
+// encryptedStore persists the token JSON encrypted with AES-256-GCM,
+// keyed by an Argon2id hash of a user passphrase. The file layout is
+// salt(16) || nonce(12) || ciphertext.
+type encryptedStore struct {
+	path string
+}
+
+func (s *encryptedStore) Load() (*oauth2.Token, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < saltLen+12 {
+		return nil, fmt.Errorf("token file %s is too short to be valid", s.path)
+	}
+	salt, nonce, ciphertext := data[:saltLen], data[saltLen:saltLen+12], data[saltLen+12:]
+	passphrase, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGcm(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt token (wrong passphrase?): %v", err)
+	}
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal(plaintext, tok); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+func (s *encryptedStore) Save(tok *oauth2.Token) error {
+	plaintext, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	passphrase, err := passphrase()
+	if err != nil {
+		return err
+	}
+	gcm, err := newGcm(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
+	data := append(append(salt, nonce...), ciphertext...)
+	fmt.Printf("Saving encrypted credential file to: %s\n", s.path)
+	return ioutil.WriteFile(s.path, data, 0600)
+}
+
+// newGcm derives an AES-256 key from passphrase and salt via Argon2id and
+// wraps it in a GCM AEAD.
+func newGcm(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey(passphrase, salt, argonIterations, argonMemoryKiB, argonThreads, argonKeyLen)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// passphrase reads the encryption passphrase from GMAIL_TOKEN_PASSPHRASE,
+// falling back to an interactive, non-echoing prompt.
+func passphrase() ([]byte, error) {
+	if v := os.Getenv(passphraseEnvVar); v != "" {
+		return []byte(v), nil
+	}
+	fmt.Print("Token passphrase: ")
+	pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read passphrase: %v", err)
+	}
+	return pass, nil
+}
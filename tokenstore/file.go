@@ -0,0 +1,40 @@
+package tokenstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"golang.org/x/oauth2"
+)
+
+// fileStore writes the token as plaintext JSON, the tool's original
+// behavior. It relies on the cache directory's 0700 permissions for
+// protection - kept around for users who don't want the extra passphrase
+// prompt that "encrypted" requires.
+type fileStore struct {
+	path string
+}
+
+func (s *fileStore) Load() (*oauth2.Token, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tok := &oauth2.Token{}
+	if err := json.NewDecoder(f).Decode(tok); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+func (s *fileStore) Save(tok *oauth2.Token) error {
+	fmt.Printf("Saving credential file to: %s\n", s.path)
+	f, err := os.Create(s.path)
+	if err != nil {
+		return fmt.Errorf("unable to cache oauth token: %v", err)
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(tok)
+}
@@ -0,0 +1,38 @@
+package tokenstore
+
+import (
+	"encoding/json"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/oauth2"
+)
+
+// keyringService/keyringUser identify the single token entry this tool
+// keeps in the OS keychain (macOS Keychain, Secret Service on Linux,
+// Windows Credential Manager).
+const keyringService = "gmailmessagessize"
+const keyringUser = "gmail-token"
+
+// keyringStore persists the token JSON in the OS keychain via
+// github.com/zalando/go-keyring, so it never touches disk at all.
+type keyringStore struct{}
+
+func (s *keyringStore) Load() (*oauth2.Token, error) {
+	data, err := keyring.Get(keyringService, keyringUser)
+	if err != nil {
+		return nil, err
+	}
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(data), tok); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+func (s *keyringStore) Save(tok *oauth2.Token) error {
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	return keyring.Set(keyringService, keyringUser, string(data))
+}
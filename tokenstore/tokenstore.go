@@ -0,0 +1,37 @@
+// Package tokenstore persists the OAuth token used to talk to Gmail.
+// tokenFromFile/saveToken used to write it as plaintext JSON; this
+// package keeps that as the "file" backend for compatibility and adds
+// "encrypted" (Argon2id-derived AES-256-GCM) and "keyring" (OS keychain)
+// backends, selected by --tokenStore.
+package tokenstore
+
+import (
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+// Store loads and saves the single OAuth token the tool needs to talk to
+// Gmail.
+type Store interface {
+	// Load returns the cached token, or an error if none is cached yet.
+	Load() (*oauth2.Token, error)
+	// Save persists tok, overwriting whatever was cached before.
+	Save(tok *oauth2.Token) error
+}
+
+// New builds the Store selected by kind. path is the token cache file
+// path for the "file" and "encrypted" backends; it is ignored by
+// "keyring", which keys off the OS keychain instead.
+func New(kind string, path string) (Store, error) {
+	switch kind {
+	case "file":
+		return &fileStore{path: path}, nil
+	case "encrypted":
+		return &encryptedStore{path: path}, nil
+	case "keyring":
+		return &keyringStore{}, nil
+	default:
+		return nil, fmt.Errorf("unknown tokenStore: %s. Expected file, encrypted or keyring", kind)
+	}
+}
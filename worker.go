@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+
+	"github.com/Labutin/GMailMessagesSize/mstore"
+	"github.com/Labutin/GMailMessagesSize/storage"
+)
+
+// gmailQuotaUnitsPerSecond and gmailGetMessageCost come from Gmail's
+// documented per-user quota: 250 quota units/second, 5 units per
+// messages.get call.
+const gmailQuotaUnitsPerSecond = 250
+const gmailGetMessageCost = 5
+const defaultQps = float64(gmailQuotaUnitsPerSecond) / gmailGetMessageCost
+
+const maxBackoff = 60 * time.Second
+
+// ProcessConfig bundles the knobs that control the processMessages
+// worker pool, so that callers (main, the daemon loop) don't have to
+// thread five positional arguments through.
+type ProcessConfig struct {
+	ProcNum    int
+	MaxRetries int
+	Qps        float64
+	Burst      int
+}
+
+// inFlightSet tracks message ids that have been dispatched to a worker
+// but not yet marked processed/removed, so the dispatch loop in
+// processMessages doesn't re-fetch a message that's still in flight
+// from an earlier FindUnprocessed batch.
+type inFlightSet struct {
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+func newInFlightSet() *inFlightSet {
+	return &inFlightSet{ids: make(map[string]struct{})}
+}
+
+// add reports whether id was newly added, i.e. whether it wasn't
+// already in flight.
+func (s *inFlightSet) add(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+	s.ids[id] = struct{}{}
+	return true
+}
+
+func (s *inFlightSet) done(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ids, id)
+}
+
+// processMessage fetches message details for every id received on in,
+// retrying transient/rate-limit errors with exponential backoff before
+// giving up on a message and removing it from the queue. inFlight is
+// cleared of an id once it has been handled (processed or removed).
+func processMessage(store mstore.MessageStore, backend storage.Backend, in <-chan string, limiter *rate.Limiter, maxRetries int, wg *sync.WaitGroup, inFlight *inFlightSet) {
+	defer wg.Done()
+	for messageId := range in {
+		fmt.Print(".")
+		if err := limiter.Wait(context.Background()); err != nil {
+			log.Printf("rate limiter wait error: %v\n", err)
+		}
+		message, err := getMessageWithRetry(store, messageId, maxRetries)
+		if err != nil {
+			if isNotFound(err) {
+				fmt.Print("NF")
+				if err := backend.RemoveMessage(messageId); err != nil {
+					fmt.Printf("Can't delete message info: %v\n", err)
+				}
+			} else {
+				fmt.Printf("\nGiving up on message %s: %v\n", messageId, err)
+				if err := backend.RemoveMessage(messageId); err != nil {
+					fmt.Printf("Can't delete message info: %v\n", err)
+				}
+			}
+			inFlight.done(messageId)
+			continue
+		}
+		metrics.IncProcessed()
+		err = backend.MarkProcessed(messageId, storage.Message{
+			LabelIds:        message.LabelIds,
+			SizeEstimate:    message.SizeEstimate,
+			InternalDate:    message.InternalDate,
+			From:            message.From,
+			ThreadId:        message.ThreadId,
+			AttachmentsSize: message.AttachmentsSize,
+		})
+		if err != nil {
+			log.Fatalf("Can't update message info: %v\n", err)
+		}
+		inFlight.done(messageId)
+	}
+}
+
+// getMessageWithRetry calls store.GetMessage, retrying rate-limit (403/
+// 429) and transient (5xx) errors up to maxRetries times with
+// exponential backoff and jitter, honoring a Retry-After header when the
+// server sends one.
+func getMessageWithRetry(store mstore.MessageStore, messageId string, maxRetries int) (*mstore.MessageInfo, error) {
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		message, err := store.GetMessage(messageId)
+		if err == nil {
+			return message, nil
+		}
+		lastErr = err
+		if isNotFound(err) || !isRetryable(err) {
+			return nil, err
+		}
+		if attempt == maxRetries {
+			break
+		}
+		metrics.IncRetries()
+		wait := backoffWithJitter(attempt, retryAfter(err))
+		metrics.AddQuotaBlocked(wait)
+		fmt.Print("S")
+		time.Sleep(wait)
+	}
+	return nil, lastErr
+}
+
+// isRetryable reports whether err looks like a rate-limit (403/429) or
+// transient (5xx) googleapi error, or a store-agnostic mstore.Retryable
+// error (e.g. a transient IMAP connection failure), worth retrying.
+func isRetryable(err error) bool {
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		switch apiErr.Code {
+		case 403, 429, 500, 502, 503, 504:
+			return true
+		}
+		return false
+	}
+	return mstore.IsRetryable(err)
+}
+
+// isNotFound reports whether err is a googleapi 404, or a store-agnostic
+// mstore.NotFound error (e.g. an IMAP message that no longer exists).
+func isNotFound(err error) bool {
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		return apiErr.Code == 404
+	}
+	return mstore.IsNotFound(err)
+}
+
+// retryAfter extracts the Retry-After header from a googleapi error, if
+// present.
+func retryAfter(err error) time.Duration {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok || apiErr.Header == nil {
+		return 0
+	}
+	v := apiErr.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := time.ParseDuration(v + "s"); err == nil {
+		return secs
+	}
+	return 0
+}
+
+// backoffWithJitter returns an exponential backoff duration for the
+// given attempt (0-based), capped at maxBackoff, with up to 50% jitter
+// added on top. If the server told us to wait longer via Retry-After,
+// that takes precedence.
+func backoffWithJitter(attempt int, serverRetryAfter time.Duration) time.Duration {
+	base := time.Duration(1<<uint(attempt)) * time.Second
+	if base > maxBackoff {
+		base = maxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(base)/2 + 1))
+	d := base + jitter
+	if serverRetryAfter > d {
+		d = serverRetryAfter
+	}
+	return d
+}
+
+// dispatchPollInterval is how long processMessages waits before
+// re-polling FindUnprocessed when the last batch it fetched was made up
+// entirely of ids still in flight from an earlier batch.
+const dispatchPollInterval = 500 * time.Millisecond
+
+// processMessages processes all messages in queue (processed==false)
+// using a worker pool sized by cfg.ProcNum, rate-limited to cfg.Qps
+// (burst cfg.Burst) to stay within Gmail's per-user quota. It closes
+// its worker pool down before returning, so it's safe to call
+// repeatedly (e.g. once per daemon discoverInterval tick) without
+// leaking goroutines. Returns an error instead of exiting when
+// FindUnprocessed fails, so that daemon mode can log it and retry on the
+// next tick rather than taking down the whole process; a MarkProcessed
+// failure inside a worker still exits via log.Fatalf.
+//
+// FindUnprocessed(100) can return the same ids across successive calls
+// while the workers that picked them up from an earlier batch haven't
+// called MarkProcessed yet, so dispatched ids are tracked in inFlight
+// and skipped until the worker handling them is done.
+func processMessages(store mstore.MessageStore, backend storage.Backend, cfg ProcessConfig) error {
+	if cfg.ProcNum < 1 || cfg.ProcNum > 50 {
+		log.Fatal("Wrong procNum. Min=1 Max=50")
+	}
+	limiter := rate.NewLimiter(rate.Limit(cfg.Qps), cfg.Burst)
+	flagContinue := true
+	out := make(chan string)
+	inFlight := newInFlightSet()
+	var wg sync.WaitGroup
+	wg.Add(cfg.ProcNum)
+	for i := 0; i < cfg.ProcNum; i++ {
+		go processMessage(store, backend, out, limiter, cfg.MaxRetries, &wg, inFlight)
+	}
+	count := 0
+	var err error
+	for flagContinue {
+		var messages []storage.Message
+		messages, err = backend.FindUnprocessed(100)
+		if err != nil {
+			flagContinue = false
+			break
+		}
+		dispatched := 0
+		for _, m := range messages {
+			if !inFlight.add(m.Id) {
+				continue
+			}
+			out <- m.Id
+			dispatched++
+		}
+		count += dispatched
+		if count % 100 == 0 && dispatched > 0 {
+			fmt.Printf("Procecced %d messages\n", count)
+		}
+		if len(messages) == 0 {
+			flagContinue = false
+		} else if dispatched == 0 {
+			time.Sleep(dispatchPollInterval)
+		}
+	}
+	close(out)
+	wg.Wait()
+	if err != nil {
+		return fmt.Errorf("can't get messages for process: %v", err)
+	}
+	return nil
+}
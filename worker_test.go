@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// fakeRetryableErr and fakeNotFoundErr satisfy mstore.Retryable/
+// mstore.NotFound structurally, so isRetryable/isNotFound can be
+// exercised against the store-agnostic path without depending on
+// mstore's unexported error types.
+type fakeRetryableErr struct{ retryable bool }
+
+func (e fakeRetryableErr) Error() string   { return "fake retryable error" }
+func (e fakeRetryableErr) Retryable() bool { return e.retryable }
+
+type fakeNotFoundErr struct{ notFound bool }
+
+func (e fakeNotFoundErr) Error() string  { return "fake not-found error" }
+func (e fakeNotFoundErr) NotFound() bool { return e.notFound }
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"googleapi 403", &googleapi.Error{Code: 403}, true},
+		{"googleapi 429", &googleapi.Error{Code: 429}, true},
+		{"googleapi 503", &googleapi.Error{Code: 503}, true},
+		{"googleapi 404", &googleapi.Error{Code: 404}, false},
+		{"googleapi 400", &googleapi.Error{Code: 400}, false},
+		{"store-agnostic retryable", fakeRetryableErr{retryable: true}, true},
+		{"store-agnostic non-retryable", fakeRetryableErr{retryable: false}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isRetryable(c.err); got != c.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"googleapi 404", &googleapi.Error{Code: 404}, true},
+		{"googleapi 500", &googleapi.Error{Code: 500}, false},
+		{"store-agnostic not found", fakeNotFoundErr{notFound: true}, true},
+		{"store-agnostic found", fakeNotFoundErr{notFound: false}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isNotFound(c.err); got != c.want {
+				t.Errorf("isNotFound(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	withHeader := &googleapi.Error{Header: http.Header{"Retry-After": []string{"5"}}}
+	if got := retryAfter(withHeader); got != 5*time.Second {
+		t.Errorf("retryAfter(with header) = %v, want 5s", got)
+	}
+	noHeader := &googleapi.Error{}
+	if got := retryAfter(noHeader); got != 0 {
+		t.Errorf("retryAfter(no header) = %v, want 0", got)
+	}
+	if got := retryAfter(fakeRetryableErr{}); got != 0 {
+		t.Errorf("retryAfter(non-googleapi error) = %v, want 0", got)
+	}
+}
+
+func TestBackoffWithJitter(t *testing.T) {
+	for attempt := 0; attempt < 8; attempt++ {
+		base := time.Duration(1<<uint(attempt)) * time.Second
+		if base > maxBackoff {
+			base = maxBackoff
+		}
+		d := backoffWithJitter(attempt, 0)
+		if d < base || d > base+base/2 {
+			t.Errorf("backoffWithJitter(%d, 0) = %v, want in [%v, %v]", attempt, d, base, base+base/2)
+		}
+	}
+	if got := backoffWithJitter(0, 10*time.Second); got < 10*time.Second {
+		t.Errorf("backoffWithJitter should honor serverRetryAfter, got %v, want >= 10s", got)
+	}
+}